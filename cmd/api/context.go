@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/harshk200/greenlight/internal/data"
+)
+
+// NOTE: custom type for the context keys so they don't collide with keys set by other packages
+type contextKey string
+
+const (
+	userContextKey       = contextKey("user")
+	userHolderContextKey = contextKey("user_holder")
+	requestIDContextKey  = contextKey("request_id")
+)
+
+// holds the user of the request for the middleware wrapping authenticate (e.g. logRequest and recoverPanic)
+// NOTE: authenticate adds the user to a copy of the request further down the chain which the outer middleware never
+// see, so they put this holder in the context instead and contextSetUser() fills it in
+type userHolder struct {
+	user *data.User
+}
+
+// returns a copy of the request with the user added to its context
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if holder, ok := r.Context().Value(userHolderContextKey).(*userHolder); ok {
+		holder.user = user
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// returns a copy of the request with an empty userHolder added to its context
+func (app *application) contextSetUserHolder(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), userHolderContextKey, &userHolder{})
+	return r.WithContext(ctx)
+}
+
+// returns the user of the request or nil if it hasn't been authenticated (yet)
+// NOTE: unlike contextGetUser() this also works in the middleware wrapping authenticate hence it's used for logging
+func (app *application) contextLookupUser(r *http.Request) *data.User {
+	if user, ok := r.Context().Value(userContextKey).(*data.User); ok {
+		return user
+	}
+
+	if holder, ok := r.Context().Value(userHolderContextKey).(*userHolder); ok {
+		return holder.user
+	}
+
+	return nil
+}
+
+// NOTE: only called when we expect a user in the context (i.e. after the authenticate middleware) hence the panic
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// returns the request id from the request context, or "" if the requestID middleware hasn't run
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"expvar"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// publishes the runtime and db pool stats as expvar variables (served on GET /debug/vars)
+// NOTE: expvar.Publish() panics if a name is published twice so this must only be called once
+func publishDebugVars(db *sql.DB) {
+	expvar.NewString("version").Set(version)
+
+	// NOTE: expvar.Func values are calculated every time /debug/vars is requested
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+
+	expvar.Publish("database", expvar.Func(func() any {
+		return db.Stats()
+	}))
+
+	expvar.Publish("timestamp", expvar.Func(func() any {
+		return time.Now().Unix()
+	}))
+}
+
+// records request/response counts and the total processing time as expvar variables
+func (app *application) debugVarsMetrics(next http.Handler) http.Handler {
+	var (
+		totalRequestsReceived           = expvar.NewInt("total_requests_received")
+		totalResponsesSent              = expvar.NewInt("total_responses_sent")
+		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+		totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		totalRequestsReceived.Add(1)
+
+		mw := newMetricsResponseWriter(w)
+
+		next.ServeHTTP(mw, r)
+
+		totalResponsesSent.Add(1)
+		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
+		totalProcessingTimeMicroseconds.Add(time.Since(start).Microseconds())
+	})
+}
+
+// serves the expvar variables like expvar.Handler() does but without "cmdline"
+// NOTE: cmdline holds the command line flags which can contain secrets e.g. -db-dns or -smtp-password
+func debugVarsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		fmt.Fprintf(w, "{\n")
+
+		first := true
+		expvar.Do(func(kv expvar.KeyValue) {
+			if kv.Key == "cmdline" {
+				return
+			}
+
+			if !first {
+				fmt.Fprintf(w, ",\n")
+			}
+			first = false
+
+			fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+		})
+
+		fmt.Fprintf(w, "\n}\n")
+	})
+}
@@ -5,9 +5,28 @@ import (
 	"net/http"
 )
 
-// TODO: later include additional info about the request
+// logs the error along with info about the request that caused it
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Print(err)
+	app.logger.Error(err.Error(), app.requestLogAttrs(r)...)
+}
+
+// returns the method, url, request id and user id of the request as log attributes
+// NOTE: the request id and user id are only there once the middleware setting them has run
+func (app *application) requestLogAttrs(r *http.Request) []any {
+	attrs := []any{
+		"method", r.Method,
+		"url", r.URL.RequestURI(),
+	}
+
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		attrs = append(attrs, "request_id", requestID)
+	}
+
+	if user := app.contextLookupUser(r); user != nil && !user.IsAnonymous() {
+		attrs = append(attrs, "user_id", user.ID)
+	}
+
+	return attrs
 }
 
 // NOTE: for message we are expecting any struct that we'll JSON encode
@@ -16,6 +35,11 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
     // what we are sending like data, movie, etc.. (error in our case)
 	env := envelope{"error": message}
 
+	// NOTE: so the client can tell us which request failed and we can find it in the logs
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		env["request_id"] = requestID
+	}
+
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
@@ -53,3 +77,49 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
     message := "unable to process the record due to an edit conflict, please try again"
     app.errorResponse(w, r, http.StatusConflict, message)
 }
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// NOTE: the WWW-Authenticate header tells the client to authenticate using a bearer token
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// the client went away before the query finished so there's no one to send the response to, the error is only logged
+// NOTE: 499 (client closed request, borrowed from nginx) so the access log and metrics don't count it as a 500
+func (app *application) queryCanceledResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warn(err.Error(), app.requestLogAttrs(r)...)
+
+	w.WriteHeader(499)
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since you last fetched it, please fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
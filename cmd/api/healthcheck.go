@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// liveness check, NOTE: kept cheap (no db calls) since it only tells whether the process is up
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	response := envelope{
 		"status": "available",
@@ -20,3 +23,70 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readiness check, probes the dependencies (the db) and responds with 503 if any of them is down so the orchestrator
+// stops routing traffic to this instance
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ready := true
+
+	// database check
+	database := map[string]any{"status": "up"}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err := app.models.DB.PingContext(ctx)
+	database["latency"] = time.Since(start).String()
+	database["pool"] = app.models.DB.Stats()
+
+	if err != nil {
+		ready = false
+		database["status"] = "down"
+		database["error"] = err.Error()
+	}
+
+	// migrations check NOTE: a dirty schema means a migration failed half way through and needs fixing by hand
+	migrations := map[string]any{"status": "up"}
+
+	// NOTE: no point querying the schema when the ping already failed, it would only wait out the same timeout
+	if !ready {
+		migrations["status"] = "skipped"
+	} else {
+		schemaVersion, dirty, err := app.models.SchemaVersion(ctx)
+		switch {
+		case err != nil:
+			ready = false
+			migrations["status"] = "down"
+			migrations["error"] = err.Error()
+		case dirty:
+			ready = false
+			migrations["status"] = "down"
+			migrations["version"] = schemaVersion
+			migrations["dirty"] = true
+		default:
+			migrations["version"] = schemaVersion
+			migrations["dirty"] = false
+		}
+	}
+
+	status := http.StatusOK
+	response := envelope{
+		"status": "ready",
+		"checks": map[string]any{
+			"database":   database,
+			"migrations": migrations,
+		},
+		"uptime": time.Since(app.startedAt).Round(time.Second).String(),
+	}
+
+	if !ready {
+		status = http.StatusServiceUnavailable
+		response["status"] = "unavailable"
+	}
+
+	err = app.writeJSON(w, status, response, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
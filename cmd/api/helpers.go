@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/harshk200/greenlight/internal/data"
 	"github.com/harshk200/greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -106,6 +108,18 @@ func (app *application) readIDParam(ps *httprouter.Params) (int64, error) {
 	return id, nil
 }
 
+func (app *application) readVersionParam(ps *httprouter.Params) (int32, error) {
+	versionStr := ps.ByName("version")
+
+	version, err := strconv.ParseInt(versionStr, 10, 32)
+	// NOTE: versions start at 1
+	if err != nil || version < 1 {
+		return 0, errors.New("Invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	q := qs.Get(key)
 
@@ -141,3 +155,97 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	return value
 }
+
+// runs the given function in a background goroutine, recovering from any panic so it doesn't crash the server
+// NOTE: the goroutine is tracked by app.wg so a graceful shutdown waits for it to finish
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%s", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// returns the ip address of the client that made the request
+// NOTE: X-Forwarded-For can be set to anything by the client so it's only used when the trusted-proxy flag is set,
+// and even then the last entry is used since that's the one appended by our proxy
+func (app *application) clientIP(r *http.Request) (string, error) {
+	if app.config.limiter.trustedProxy {
+		forwardedFor := r.Header.Get("X-Forwarded-For")
+		if forwardedFor != "" {
+			ips := strings.Split(forwardedFor, ",")
+			return strings.TrimSpace(ips[len(ips)-1]), nil
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	return ip, nil
+}
+
+// returns the ETag of the movie, it changes every time the movie is updated since the version is bumped
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"movie-%d-v%d"`, movie.ID, movie.Version)
+}
+
+// returns true if the If-Match/If-None-Match header value (a comma separated list of etags or *) matches the etag
+// NOTE: If-None-Match uses weak comparison (the W/ prefix is ignored) and If-Match strong comparison (RFC 9110)
+func etagMatches(header, etag string, weak bool) bool {
+	if header == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns the movie version from the If-Match header (a comma separated list of etags or *) using the first etag of
+// the movie with the given id, the same list etagMatches() reads
+// NOTE: "*" matches any version (returns 0) and if no etag is for the movie nothing can match so ok is false
+func ifMatchVersion(ifMatch string, id int64) (int32, bool) {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return 0, true
+		}
+
+		var etagID int64
+		var version int32
+
+		_, err := fmt.Sscanf(candidate, `"movie-%d-v%d"`, &etagID, &version)
+		if err != nil || etagID != id || version < 1 || movieETag(&data.Movie{ID: etagID, Version: version}) != candidate {
+			continue
+		}
+
+		return version, true
+	}
+
+	return 0, false
+}
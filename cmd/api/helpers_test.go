@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestIfMatchVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifMatch     string
+		wantVersion int32
+		wantOK      bool
+	}{
+		{"single etag", `"movie-7-v3"`, 3, true},
+		{"any", `*`, 0, true},
+		{"list", `"movie-1-v2", "movie-7-v4"`, 4, true},
+		{"list without spaces", `"movie-1-v2","movie-7-v5"`, 5, true},
+		{"other movie", `"movie-1-v2"`, 0, false},
+		{"weak etag", `W/"movie-7-v3"`, 0, false},
+		{"malformed", `movie-7-v3`, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, ok := ifMatchVersion(tt.ifMatch, 7)
+			if version != tt.wantVersion || ok != tt.wantOK {
+				t.Errorf("got (%d, %t); want (%d, %t)", version, ok, tt.wantVersion, tt.wantOK)
+			}
+		})
+	}
+}
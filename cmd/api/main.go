@@ -3,14 +3,17 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
-	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/harshk200/greenlight/internal/data"
+	"github.com/harshk200/greenlight/internal/mailer"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -18,20 +21,48 @@ import (
 const version = "1.0.0"
 
 type config struct {
-	port int
-	env  string // (Can be production or development or staging) This will be used for testing later
-	db   struct {
+	port            int
+	env             string        // (Can be production or development or staging) This will be used for testing later
+	logLevel        string        // debug|info|warn|error
+	shutdownTimeout time.Duration // grace period for in-flight requests and background tasks on shutdown
+	debugVars       bool          // NOTE: exposes GET /debug/vars, keep it off in production unless needed
+	db              struct {
 		dns          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		queryTimeout time.Duration
+	}
+	smtp struct {
+		host     string // NOTE: only optional in development where the emails are logged instead of being sent
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	trash struct {
+		retention     time.Duration // how long soft deleted movies are kept before they're purged
+		purgeInterval time.Duration
+	}
+	cors struct {
+		trustedOrigins []string // NOTE: origins allowed to make cross-origin requests e.g. https://admin.greenlight.com
+	}
+	limiter struct {
+		enabled      bool
+		rps          float64 // requests per second allowed for each client
+		burst        int
+		trustedProxy bool // NOTE: the client ip is only read from X-Forwarded-For when we're behind a proxy we trust
 	}
 }
 
 type application struct {
-	config config
-	logger *log.Logger
-	models data.Models
+	config    config
+	logger    *slog.Logger
+	models    data.Models
+	mailer    mailer.Mailer
+	metrics   *appMetrics
+	startedAt time.Time      // NOTE: used for the uptime in the readiness check
+	wg        sync.WaitGroup // NOTE: tracks the background goroutines so shutdown can wait for them
 }
 
 func openDB(cfg *config) (*sql.DB, error) {
@@ -62,6 +93,43 @@ func openDB(cfg *config) (*sql.DB, error) {
 	return db, nil
 }
 
+// creates a structured leveled logger writing to stdout
+// NOTE: json logs everywhere except the development env where the human readable text format is used
+// checks the flag values that would otherwise break the server at runtime instead of at startup
+func validateConfig(cfg *config) error {
+	// NOTE: every movie query would time out straight away otherwise
+	if cfg.db.queryTimeout <= 0 {
+		return errors.New("-db-query-timeout must be greater than 0")
+	}
+
+	if cfg.trash.retention < 0 {
+		return errors.New("-trash-retention must not be negative")
+	}
+
+	if cfg.trash.purgeInterval < 0 {
+		return errors.New("-trash-purge-interval must not be negative")
+	}
+
+	return nil
+}
+
+func newLogger(cfg *config) (*slog.Logger, error) {
+	var level slog.Level
+
+	err := level.UnmarshalText([]byte(cfg.logLevel))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	if cfg.env == "development" {
+		return slog.New(slog.NewTextHandler(os.Stdout, opts)), nil
+	}
+
+	return slog.New(slog.NewJSONHandler(os.Stdout, opts)), nil
+}
+
 func main() {
 	// loading Environment variables
 	err := godotenv.Load()
@@ -74,39 +142,87 @@ func main() {
 	// CLI flags
 	flag.IntVar(&cfg.port, "addr", 4000, "the port/addr on which server will run")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.logLevel, "log-level", "info", "Minimum log level (debug|info|warn|error)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Graceful shutdown grace period")
 	// db connection flags
 	flag.StringVar(&cfg.db.dns, "db-dns", os.Getenv("POSTGRES_URL"), "DNS for the database (postgres-db)")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-timeout", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL per-query timeout")
+	// smtp (mailer) flags
+	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("SMTP_HOST"), "SMTP host (required outside development, emails are logged if empty)")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.harshk200.net>", "SMTP sender")
+	flag.BoolVar(&cfg.debugVars, "debug-vars-enabled", false, "Expose expvar runtime and db pool stats on /debug/vars")
+	// trash flags
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept in the trash")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often the trash is purged (0 disables purging)")
+	// cors flags
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
+		cfg.cors.trustedOrigins = strings.Fields(val)
+		return nil
+	})
+	// rate limiter flags
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable the per-client rate limiter")
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.BoolVar(&cfg.limiter.trustedProxy, "trusted-proxy", false, "Use the X-Forwarded-For header for the client ip (only when behind a trusted proxy)")
 	flag.Parse()
 
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	logger, err := newLogger(&cfg)
+	if err != nil {
+		log.Fatal("Error setting up the logger", err)
+	}
+
+	err = validateConfig(&cfg)
+	if err != nil {
+		logger.Error("invalid config", "error", err.Error())
+		os.Exit(1)
+	}
 
 	// DB connection pool setup
 	db, err := openDB(&cfg)
 	if err != nil {
-		log.Fatal("Error connecting to the db", err)
+		logger.Error("error connecting to the db", "error", err.Error())
+		os.Exit(1)
 	}
 	defer db.Close()
 
-	logger.Println("postgres DB connection established")
+	logger.Info("postgres DB connection established")
 
-	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+	var mail mailer.Mailer
+
+	switch {
+	case cfg.smtp.host != "":
+		mail = mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+	case cfg.env == "development":
+		logger.Warn("no smtp host provided, emails will be logged and not sent")
+		mail = mailer.NewLog(logger)
+	default:
+		// NOTE: users could never activate their accounts without emails being sent
+		logger.Error("an smtp host must be provided outside the development env")
+		os.Exit(1)
+	}
+
+	if cfg.debugVars {
+		publishDebugVars(db)
 	}
 
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  time.Second * 10,
-		WriteTimeout: time.Second * 30,
+	app := &application{
+		config:    cfg,
+		logger:    logger,
+		models:    data.NewModels(db, cfg.db.queryTimeout),
+		mailer:    mail,
+		metrics:   newAppMetrics(db),
+		startedAt: time.Now(),
 	}
 
-	logger.Printf("starting %s server on %s", cfg.env, srv.Addr)
-	err = srv.ListenAndServe()
-	logger.Fatal(err)
+	err = app.serve()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// prometheus metrics exposed on GET /metrics
+type appMetrics struct {
+	registry         *prometheus.Registry
+	requestsTotal    *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+}
+
+// constructor
+// NOTE: a registry of our own instead of the global default one so only the metrics registered here are exported
+func newAppMetrics(db *sql.DB) *appMetrics {
+	m := &appMetrics{
+		registry: prometheus.NewRegistry(),
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "greenlight",
+			Name:      "http_requests_total",
+			Help:      "Total number of http requests by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "greenlight",
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of http requests by route pattern, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "greenlight",
+			Name:      "http_requests_in_flight",
+			Help:      "Number of http requests currently being served.",
+		}),
+	}
+
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "greenlight",
+		Name:        "build_info",
+		Help:        "Always 1, labelled with the version of the running binary.",
+		ConstLabels: prometheus.Labels{"version": version},
+	})
+	buildInfo.Set(1)
+
+	m.registry.MustRegister(
+		m.requestsTotal,
+		m.requestDuration,
+		m.requestsInFlight,
+		buildInfo,
+		// NOTE: exports db.Stats() i.e. open/idle/in use connections, wait count and wait duration
+		collectors.NewDBStatsCollector(db, "greenlight"),
+		collectors.NewGoCollector(),
+	)
+
+	return m
+}
+
+func (m *appMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// records the request count, latency and in-flight requests for every request
+// NOTE: the router is needed to label requests with the route pattern (e.g. /v1/movies/:id) instead of the raw path,
+// otherwise every movie id would create a new time series
+func (app *application) collectMetrics(router *routeTable, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.metrics.requestsInFlight.Inc()
+		defer app.metrics.requestsInFlight.Dec()
+
+		mw := newMetricsResponseWriter(w)
+
+		next.ServeHTTP(mw, r)
+
+		labels := prometheus.Labels{
+			"route":  routePattern(router, r),
+			"method": r.Method,
+			"status": strconv.Itoa(mw.statusCode),
+		}
+
+		app.metrics.requestsTotal.With(labels).Inc()
+		app.metrics.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// returns the pattern of the route the request matched (e.g. /v1/movies/:id)
+// NOTE: requests that don't match any route are all labelled "unmatched"
+func routePattern(router *routeTable, r *http.Request) string {
+	handle, ps, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "unmatched"
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+
+	// NOTE: httprouter doesn't allow a static segment and a param at the same position so only one pattern can match
+	for _, pattern := range router.patterns[r.Method] {
+		if patternMatches(strings.Split(pattern, "/"), segments, ps) {
+			return pattern
+		}
+	}
+
+	return "unmatched"
+}
+
+// returns true if the path segments match the pattern segments position by position, the static ones by their text
+// and the params by the value httprouter gave them
+func patternMatches(pattern, segments []string, ps httprouter.Params) bool {
+	if len(pattern) != len(segments) {
+		return false
+	}
+
+	for i := range pattern {
+		if strings.HasPrefix(pattern[i], ":") {
+			if ps.ByName(pattern[i][1:]) != segments[i] {
+				return false
+			}
+		} else if pattern[i] != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// routeTable is a httprouter.Router that records the patterns of the routes registered on it for routePattern()
+// NOTE: httprouter (v1.3) has no way to tell which pattern a request matched
+type routeTable struct {
+	*httprouter.Router
+	patterns map[string][]string // by method
+}
+
+// constructor
+func newRouteTable() *routeTable {
+	return &routeTable{
+		Router:   httprouter.New(),
+		patterns: make(map[string][]string),
+	}
+}
+
+func (rt *routeTable) Handle(method, path string, handle httprouter.Handle) {
+	rt.patterns[method] = append(rt.patterns[method], path)
+	rt.Router.Handle(method, path, handle)
+}
+
+// NOTE: the shortcuts have to be redefined, httprouter's call its own Handle() which wouldn't record the pattern
+func (rt *routeTable) Handler(method, path string, handler http.Handler) {
+	rt.patterns[method] = append(rt.patterns[method], path)
+	rt.Router.Handler(method, path, handler)
+}
+
+func (rt *routeTable) GET(path string, handle httprouter.Handle) {
+	rt.Handle(http.MethodGet, path, handle)
+}
+
+func (rt *routeTable) POST(path string, handle httprouter.Handle) {
+	rt.Handle(http.MethodPost, path, handle)
+}
+
+func (rt *routeTable) PUT(path string, handle httprouter.Handle) {
+	rt.Handle(http.MethodPut, path, handle)
+}
+
+func (rt *routeTable) PATCH(path string, handle httprouter.Handle) {
+	rt.Handle(http.MethodPatch, path, handle)
+}
+
+func (rt *routeTable) DELETE(path string, handle httprouter.Handle) {
+	rt.Handle(http.MethodDelete, path, handle)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestRoutePattern(t *testing.T) {
+	noop := func(http.ResponseWriter, *http.Request, httprouter.Params) {}
+
+	router := newRouteTable()
+	router.GET("/v1/movies", noop)
+	router.GET("/v1/movies/:id", noop)
+	router.GET("/v1/movies/:id/history/:version", noop)
+	router.PUT("/v1/users/activated", noop)
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodGet, "/v1/movies", "/v1/movies"},
+		{http.MethodGet, "/v1/movies/7", "/v1/movies/:id"},
+		{http.MethodGet, "/v1/movies/movies", "/v1/movies/:id"},
+		{http.MethodGet, "/v1/movies/v1", "/v1/movies/:id"},
+		{http.MethodGet, "/v1/movies/3/history/3", "/v1/movies/:id/history/:version"},
+		{http.MethodPut, "/v1/users/activated", "/v1/users/activated"},
+		{http.MethodGet, "/v1/users/activated", "unmatched"},
+		{http.MethodGet, "/v1/unknown", "unmatched"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			got := routePattern(router, httptest.NewRequest(tt.method, tt.path, nil))
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshk200/greenlight/internal/data"
+	"github.com/harshk200/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/time/rate"
+)
+
+// uses the X-Request-ID header sent by the client (or a proxy) or generates a new one, the id is stored in the
+// request context and sent back in the X-Request-ID response header
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+
+		// NOTE: the id ends up in our logs so we don't accept anything too long or with weird characters
+		if !validRequestID(requestID) {
+			randomBytes := make([]byte, 16)
+
+			_, err := rand.Read(randomBytes)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			requestID = hex.EncodeToString(randomBytes)
+		}
+
+		r = app.contextSetRequestID(r, requestID)
+		// NOTE: so the access log and the panic logs of the outer middleware have the user id too
+		r = app.contextSetUserHolder(r)
+		w.Header().Set("X-Request-ID", requestID)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+
+	for _, c := range requestID {
+		isAlphanumeric := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlphanumeric && !strings.ContainsRune("-_.:", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// logs one line per request with the response status, bytes written and how long it took
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		mw := newMetricsResponseWriter(w)
+
+		next.ServeHTTP(mw, r)
+
+		attrs := append(app.requestLogAttrs(r),
+			"status", mw.statusCode,
+			"bytes", mw.bytesWritten,
+			"duration", time.Since(start).String(),
+		)
+
+		app.logger.Info("request completed", attrs...)
+	})
+}
+
+// recovers from any panic in the handlers down the chain and sends a 500 json response instead of dropping the connection
+// NOTE: this only covers the goroutine handling the request, panics in goroutines spawned by handlers aren't caught
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// NOTE: deferred functions still run while go unwinds the stack after a panic
+		defer func() {
+			if err := recover(); err != nil {
+				// NOTE: tells go's http server to close the connection after the response has been sent
+				w.Header().Set("Connection", "close")
+
+				app.serverErrorResponse(w, r, fmt.Errorf("%s\n%s", err, debug.Stack()))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sets the CORS headers for requests coming from one of the trusted origins and responds to preflight requests
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// NOTE: the response depends on these request headers so caches must not serve it for other origins
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" {
+			for i := range app.config.cors.trustedOrigins {
+				if origin == app.config.cors.trustedOrigins[i] {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+
+					// NOTE: a preflight request is an OPTIONS request with the Access-Control-Request-Method header
+					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+						w.WriteHeader(http.StatusOK)
+						return
+					}
+
+					break
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// per-client (ip address) token bucket rate limiter, responds with 429 once a client runs out of tokens
+// NOTE: a no-op when the limiter is disabled so the cleanup goroutine below only runs when it has clients to clean up
+func (app *application) rateLimit(ctx context.Context, next http.Handler) http.Handler {
+	if !app.config.limiter.enabled {
+		return next
+	}
+
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*client)
+	)
+
+	// NOTE: background goroutine removing the clients we haven't seen in the last 3 minutes once every minute
+	// so the clients map doesn't keep growing forever, stopped when ctx is cancelled (on shutdown)
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				for ip, client := range clients {
+					if time.Since(client.lastSeen) > 3*time.Minute {
+						delete(clients, ip)
+					}
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, err := app.clientIP(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		mu.Lock()
+
+		if _, found := clients[ip]; !found {
+			clients[ip] = &client{
+				limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+			}
+		}
+
+		clients[ip].lastSeen = time.Now()
+
+		if !clients[ip].limiter.Allow() {
+			mu.Unlock()
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		// NOTE: not deferring the unlock, otherwise the mutex would stay locked until all the handlers down the chain return
+		mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticates the user from the "Authorization: Bearer <token>" header and adds them to the request context
+// NOTE: requests without the header get the data.AnonymousUser
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// NOTE: the response depends on the Authorization header so caches shouldn't mix them up
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NOTE: the middlewares below wrap individual httprouter handlers (in routes.go) instead of the whole router
+
+// responds with 401 if the user in the request context is the anonymous user
+func (app *application) requireAuthenticatedUser(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next(w, r, ps)
+	}
+}
+
+// responds with 403 if the user hasn't activated their account (and 401 if they aren't authenticated)
+func (app *application) requireActivatedUser(next httprouter.Handle) httprouter.Handle {
+	fn := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		user := app.contextGetUser(r)
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next(w, r, ps)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// responds with 403 if the user doesn't have the given permission code (and 401/403 if they aren't authenticated/activated)
+func (app *application) requirePermission(code string, next httprouter.Handle) httprouter.Handle {
+	fn := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		user := app.contextGetUser(r)
+
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next(w, r, ps)
+	}
+
+	return app.requireActivatedUser(fn)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPanicLogsCarryUserID(t *testing.T) {
+	app, token := newTestApplication(t)
+
+	var logs bytes.Buffer
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	// NOTE: same order as in routes(), the user is only set on the request below authenticate
+	handler := app.requestID(app.logRequest(app.recoverPanic(app.authenticate(panicking))))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d; want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines; want the panic and the access log, logs: %s", len(lines), logs.String())
+	}
+
+	for _, line := range lines {
+		if !strings.Contains(line, `"user_id":1`) {
+			t.Errorf("log line without the user id: %s", line)
+		}
+	}
+}
+
+func TestRateLimitStopsOnCancel(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 2
+	app.config.limiter.burst = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := app.routes(ctx)
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil))
+
+		if w.Code != want {
+			t.Fatalf("request %d: got status %d; want %d", i+1, w.Code, want)
+		}
+	}
+
+	cancel()
+
+	// NOTE: the cleanup goroutine is tracked by app.wg so Wait() only returns once it has stopped
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the rate limiter's cleanup goroutine didn't stop after the context was cancelled")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 
 	"github.com/harshk200/greenlight/internal/data"
@@ -26,6 +27,9 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request,
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v) // fetch 20 records per page by default
 	input.Filters.Sort = app.readString(qs, "sort", "id")        // sort using id by default
+	// NOTE: passing a cursor (even an empty one i.e. ?cursor=) opts in to keyset pagination
+	input.Filters.CursorMode = qs.Has("cursor")
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
 
     // sortsafelist is what you can sort by
 	input.Filters.SortSafeList = []string{
@@ -45,13 +49,18 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	movies, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Filters)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, 200, envelope{"movies": movies}, nil)
+	err = app.writeJSON(w, 200, envelope{"movies": movies, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -89,9 +98,14 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// NOTE: this call mutates the movie struct itself adding ID, CreatedAt, Version
-	err = app.models.Movies.Create(movie)
+	err = app.models.Movies.Create(r.Context(), movie, app.contextGetUser(r).ID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -112,11 +126,13 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
 		default:
 			// probably something bad with db connection if err is not ErrRecordNotFound
 			app.serverErrorResponse(w, r, err)
@@ -124,7 +140,19 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	etag := movieETag(movie)
+
+	// NOTE: the client already has the current revision of the movie so there's no need to send it again
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header := make(http.Header)
+	header.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, header)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -138,17 +166,26 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// NOTE: checking if the record exists in the DB
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	// NOTE: the client's copy of the movie is stale if its If-Match doesn't match the current revision
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && !etagMatches(ifMatch, movieETag(movie), false) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Title   *string       `json:"title"`
 		Year    *int32        `json:"year"`
@@ -183,18 +220,26 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(r.Context(), movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		// NOTE: someone else updated the movie in between our Get() and Update() so the If-Match no longer holds
+		case errors.Is(err, data.ErrEditConflict) && ifMatch != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	header := make(http.Header)
+	header.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, header)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -207,18 +252,302 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	// NOTE: the client can pass the version of the movie it expects to delete either as an If-Match ETag or as
+	// the version query parameter, the delete fails if the movie has been updated since (0 means no check)
+	var expectedVersion int32
+
+	ifMatch := r.Header.Get("If-Match")
+
+	switch {
+	case ifMatch != "":
+		version, ok := ifMatchVersion(ifMatch, id)
+		if !ok {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+		expectedVersion = version
+
+	case r.URL.Query().Has("version"):
+		v := validator.New()
+
+		version := app.readInt(r.URL.Query(), "version", 0, v)
+		v.Check(version > 0, "version", "must be greater than 0")
+		v.Check(version <= math.MaxInt32, "version", "must not be greater than 2147483647") // NOTE: versions are int32
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		expectedVersion = int32(version)
+	}
+
+	err = app.models.Movies.Delete(r.Context(), id, expectedVersion, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict) && ifMatch != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully moved to the trash"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// lists the soft deleted movies (most recently deleted first) that haven't been purged yet
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// NOTE: the trash is always sorted by deletion time, the sort field is only set so ValidateFilters() passes
+	input.Filters.Sort = "deleted_at"
+	input.Filters.SortSafeList = []string{"deleted_at"}
+
+	data.ValidateFilters(v, input.Filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAllDeleted(r.Context(), input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// takes a movie out of the trash
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := app.readIDParam(&ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Restore(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	header := make(http.Header)
+	header.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, header)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// lists the revisions of a movie, newest first (works for movies in the trash too)
+func (app *application) listMovieHistoryHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := app.readIDParam(&ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// NOTE: revisions are always sorted by version (newest first), the sort field is only set so ValidateFilters() passes
+	input.Filters.Sort = "-version"
+	input.Filters.SortSafeList = []string{"-version"}
+
+	data.ValidateFilters(v, input.Filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// NOTE: a page past the last one is an empty list, only a movie without any revisions is a 404
+	revisions, metadata, err := app.models.Movies.GetRevisions(r.Context(), id, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := app.readIDParam(&ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(&ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reverts a movie to the values of one of its previous revisions, the revert is recorded as a new revision
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := app.readIDParam(&ps)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Version *int32 `json:"version"` // the version to revert to
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Version != nil, "version", "must be provided")
+	v.Check(input.Version == nil || *input.Version > 0, "version", "must be greater than 0")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	// NOTE: same as updateMovieHandler, the revert is refused if the client's copy of the movie is stale
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && !etagMatches(ifMatch, movieETag(movie), false) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(r.Context(), id, *input.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("version", "no revision with this version exists for the movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// NOTE: only the movie's fields are copied back, the id and version stay the same so Revert() does the usual
+	// optimistic version check against the movie we just fetched
+	movie.Title = revision.Snapshot.Title
+	movie.Year = revision.Snapshot.Year
+	movie.Runtime = revision.Snapshot.Runtime
+	movie.Genres = revision.Snapshot.Genres
+
+	// NOTE: the old values might not pass the current validation rules anymore
+	data.ValidateMovie(v, movie)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Revert(r.Context(), movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && ifMatch != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrQueryCanceled):
+			app.queryCanceledResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	header := make(http.Header)
+	header.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, header)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
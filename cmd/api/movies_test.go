@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harshk200/greenlight/internal/data"
+)
+
+// returns an application backed by the mock models and an authentication token for an activated user with the given
+// permissions
+func newTestApplication(t *testing.T, permissions ...string) (*application, string) {
+	t.Helper()
+
+	app := &application{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models:    data.NewMockModels(),
+		metrics:   newAppMetrics(nil), // NOTE: the db stats are only read on a scrape of /metrics
+		startedAt: time.Now(),
+	}
+	app.config.env = "development"
+
+	user := &data.User{Name: "Test User", Email: "test@example.com", Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Permissions.AddForUser(user.ID, permissions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app, token.Plaintext
+}
+
+// sends the request to the handler with the token (if any) as a bearer token and the extra headers
+func sendRequest(handler http.Handler, method, url, body, token string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	for key, values := range header {
+		r.Header[key] = values
+	}
+
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestMovieHandlers(t *testing.T) {
+	app, token := newTestApplication(t, "movies:read", "movies:write")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	routes := app.routes(ctx)
+
+	send := func(method, url, body, token string) *httptest.ResponseRecorder {
+		return sendRequest(routes, method, url, body, token, nil)
+	}
+
+	w := send(http.MethodPost, "/v1/movies", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"]}`, token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d; want %d, body: %s", w.Code, http.StatusCreated, w.Body)
+	}
+
+	w = send(http.MethodGet, "/v1/movies/1", "", token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("show: got status %d; want %d, body: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var response struct {
+		Movie data.Movie `json:"movie"`
+	}
+
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Movie.Title != "Moana" || response.Movie.Version != 1 {
+		t.Errorf("show: got %+v; want Moana at version 1", response.Movie)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		token  string
+		want   int
+	}{
+		{"anonymous user", http.MethodGet, "/v1/movies/1", "", http.StatusUnauthorized},
+		{"unknown token", http.MethodGet, "/v1/movies/1", "ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnauthorized},
+		{"missing movie", http.MethodGet, "/v1/movies/2", token, http.StatusNotFound},
+		{"page past the last one", http.MethodGet, "/v1/movies?page=2", token, http.StatusOK},
+		{"history", http.MethodGet, "/v1/movies/1/history", token, http.StatusOK},
+		{"history page past the last one", http.MethodGet, "/v1/movies/1/history?page=2", token, http.StatusOK},
+		{"history of a missing movie", http.MethodGet, "/v1/movies/2/history", token, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(tt.method, tt.url, "", tt.token)
+			if w.Code != tt.want {
+				t.Errorf("got status %d; want %d, body: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+// NOTE: the steps run in order against the same movie, each one starts from the state the previous one left
+func TestMovieWriteHandlers(t *testing.T) {
+	app, token := newTestApplication(t, "movies:read", "movies:write")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	routes := app.routes(ctx)
+
+	steps := []struct {
+		name        string
+		method      string
+		url         string
+		ifMatch     string
+		body        string
+		want        int
+		wantVersion int32 // NOTE: checked with a GET after the step, 0 means the movie isn't expected to be found
+	}{
+		{"create", http.MethodPost, "/v1/movies", "", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusCreated, 1},
+		{"update", http.MethodPatch, "/v1/movies/1", `"movie-1-v1"`, `{"title": "Moana 2"}`, http.StatusOK, 2},
+		{"update with a stale If-Match", http.MethodPatch, "/v1/movies/1", `"movie-1-v1"`, `{"title": "Moana 3"}`, http.StatusPreconditionFailed, 2},
+		{"delete a stale version", http.MethodDelete, "/v1/movies/1?version=1", "", "", http.StatusConflict, 2},
+		{"delete a version out of range", http.MethodDelete, "/v1/movies/1?version=4294967298", "", "", http.StatusUnprocessableEntity, 2},
+		{"delete", http.MethodDelete, "/v1/movies/1?version=2", "", "", http.StatusOK, 0},
+		{"update a deleted movie", http.MethodPatch, "/v1/movies/1", "", `{"title": "Moana 3"}`, http.StatusNotFound, 0},
+		{"restore", http.MethodPost, "/v1/movies/1/restore", "", "", http.StatusOK, 4},
+		{"restore a movie that isn't deleted", http.MethodPost, "/v1/movies/1/restore", "", "", http.StatusNotFound, 4},
+		{"revert with a stale If-Match", http.MethodPost, "/v1/movies/1/revert", `"movie-1-v3"`, `{"version": 1}`, http.StatusPreconditionFailed, 4},
+		{"revert", http.MethodPost, "/v1/movies/1/revert", `"movie-1-v4"`, `{"version": 1}`, http.StatusOK, 5},
+	}
+
+	for _, step := range steps {
+		header := http.Header{}
+		if step.ifMatch != "" {
+			header.Set("If-Match", step.ifMatch)
+		}
+
+		w := sendRequest(routes, step.method, step.url, step.body, token, header)
+		if w.Code != step.want {
+			t.Fatalf("%s: got status %d; want %d, body: %s", step.name, w.Code, step.want, w.Body)
+		}
+
+		w = sendRequest(routes, http.MethodGet, "/v1/movies/1", "", token, nil)
+
+		switch {
+		case step.wantVersion == 0 && w.Code != http.StatusNotFound:
+			t.Fatalf("%s: got status %d for the movie; want %d", step.name, w.Code, http.StatusNotFound)
+		case step.wantVersion != 0 && w.Header().Get("ETag") != movieETag(&data.Movie{ID: 1, Version: step.wantVersion}):
+			t.Fatalf("%s: got ETag %s for the movie; want version %d", step.name, w.Header().Get("ETag"), step.wantVersion)
+		}
+	}
+
+	// NOTE: the revert brings back the title of version 1 as a new version
+	var response struct {
+		Movie data.Movie `json:"movie"`
+	}
+
+	w := sendRequest(routes, http.MethodGet, "/v1/movies/1", "", token, nil)
+
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Movie.Title != "Moana" {
+		t.Errorf("got title %q after the revert; want %q", response.Movie.Title, "Moana")
+	}
+}
+
+func TestMovieHandlersRequirePermission(t *testing.T) {
+	app, token := newTestApplication(t, "movies:read")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := sendRequest(app.routes(ctx), http.MethodPost, "/v1/movies", `{"title": "Moana"}`, token, nil)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d; want %d, body: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+}
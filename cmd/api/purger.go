@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// permanently deletes the movies that have been in the trash for longer than the retention period, once every
+// purge interval until ctx is cancelled (on shutdown)
+// NOTE: tracked by app.wg like the other background goroutines so the shutdown waits for a running purge
+// NOTE: a purge interval of 0 disables the purger, the trash is then kept forever
+func (app *application) startTrashPurger(ctx context.Context) {
+	if app.config.trash.purgeInterval == 0 {
+		app.logger.Info("trash purger disabled")
+		return
+	}
+
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(app.config.trash.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deletedBefore := time.Now().Add(-app.config.trash.retention)
+
+				purged, err := app.models.Movies.PurgeDeleted(ctx, deletedBefore)
+				if err != nil {
+					app.logger.Error("purging the trash failed", "error", err.Error())
+					continue
+				}
+
+				if purged > 0 {
+					app.logger.Info("purged movies from the trash", "count", purged)
+				}
+			}
+		}
+	}()
+}
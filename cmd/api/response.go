@@ -0,0 +1,47 @@
+package main
+
+import "net/http"
+
+// wraps a http.ResponseWriter to capture the status code and the no. of bytes written by the handlers
+type metricsResponseWriter struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	headerWritten bool
+	bytesWritten  int
+}
+
+// NOTE: the status code defaults to 200 since that's what go sends if the handler never calls WriteHeader()
+func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
+	return &metricsResponseWriter{
+		wrapped:    w,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (mw *metricsResponseWriter) Header() http.Header {
+	return mw.wrapped.Header()
+}
+
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	mw.wrapped.WriteHeader(statusCode)
+
+	// NOTE: only the first call to WriteHeader() counts (go ignores the superfluous ones)
+	if !mw.headerWritten {
+		mw.statusCode = statusCode
+		mw.headerWritten = true
+	}
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	mw.headerWritten = true
+
+	n, err := mw.wrapped.Write(b)
+	mw.bytesWritten += n
+
+	return n, err
+}
+
+// NOTE: lets http.ResponseController get to the underlying ResponseWriter (for Flush() etc.)
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.wrapped
+}
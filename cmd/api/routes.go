@@ -1,25 +1,65 @@
 package main
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-func (app *application) routes() *httprouter.Router {
-	router := httprouter.New()
+// NOTE: ctx stops the background goroutines of the middleware (e.g. the rate limiter's cleanup) when it's cancelled
+func (app *application) routes(ctx context.Context) http.Handler {
+	// NOTE: records the route patterns for the metrics labels, otherwise the same as httprouter.New()
+	router := newRouteTable()
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	// routes
 	router.GET("/v1/healthcheck", app.healthcheckHandler)
+	router.GET("/v1/readiness", app.readinessHandler)
+	router.Handler(http.MethodGet, "/metrics", app.metrics.handler())
+	if app.config.debugVars {
+		router.Handler(http.MethodGet, "/debug/vars", debugVarsHandler())
+	}
 	//movies routes
-    router.GET("/v1/movies", app.listMovieHandler) // HACK: work in progress
-	router.POST("/v1/movies", app.createMovieHandler)
-	router.GET("/v1/movies/:id", app.showMovieHandler)
-	router.PATCH("/v1/movies/:id", app.updateMovieHandler)
-	router.DELETE("/v1/movies/:id", app.deleteMovieHandler)
+	router.GET("/v1/movies", app.requirePermission("movies:read", app.listMovieHandler))
+	router.POST("/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	// NOTE: httprouter doesn't allow /v1/movies/trash next to /v1/movies/:id hence the trash route is dispatched on the
+	// value of the :id param
+	router.GET("/v1/movies/:id", app.routeParamValue("id", "trash",
+		app.requirePermission("movies:write", app.listTrashHandler),
+		app.requirePermission("movies:read", app.showMovieHandler),
+	))
+	router.PATCH("/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	router.DELETE("/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.POST("/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+	router.GET("/v1/movies/:id/history", app.requirePermission("movies:read", app.listMovieHistoryHandler))
+	router.GET("/v1/movies/:id/history/:version", app.requirePermission("movies:read", app.showMovieRevisionHandler))
+	router.POST("/v1/movies/:id/revert", app.requirePermission("movies:write", app.revertMovieHandler))
+	// users routes
+	router.POST("/v1/users", app.registerUserHandler)
+	router.PUT("/v1/users/activated", app.activateUserHandler)
+	// tokens routes
+	router.POST("/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 
-	return router
+	var handler http.Handler = app.recoverPanic(app.enableCORS(app.rateLimit(ctx, app.authenticate(router))))
+
+	if app.config.debugVars {
+		handler = app.debugVarsMetrics(handler)
+	}
+
+	return app.requestID(app.logRequest(app.collectMetrics(router, handler)))
+}
+
+// calls match if the named route param has the given value and fallback otherwise
+func (app *application) routeParamValue(name, value string, match, fallback httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if ps.ByName(name) == value {
+			match(w, r, ps)
+			return
+		}
+
+		fallback(w, r, ps)
+	}
 }
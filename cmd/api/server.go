@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// starts the http server and shuts it down gracefully on SIGINT/SIGTERM
+// NOTE: in-flight requests and background goroutines get the configured grace period to finish
+func (app *application) serve() error {
+	// NOTE: cancelled on shutdown to stop the long running background goroutines (e.g. the trash purger)
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(backgroundCtx),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  time.Second * 10,
+		WriteTimeout: time.Second * 30,
+	}
+
+	shutdownError := make(chan error)
+
+	app.startTrashPurger(backgroundCtx)
+
+	go func() {
+		quit := make(chan os.Signal, 1) // NOTE: buffered so a signal isn't missed if we aren't ready to receive it yet
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+		s := <-quit // blocks until a signal is received
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		// NOTE: Shutdown() stops accepting new connections and waits for the in-flight requests to complete
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		app.logger.Info("waiting for background tasks to complete", "addr", srv.Addr)
+
+		stopBackground()
+
+		// NOTE: the background tasks get whatever is left of the grace period, Wait() has no deadline of its own
+		// hence it's done in a goroutine
+		done := make(chan struct{})
+		go func() {
+			app.wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			shutdownError <- nil
+		case <-ctx.Done():
+			shutdownError <- fmt.Errorf("background tasks didn't complete within the shutdown grace period: %w", ctx.Err())
+		}
+	}()
+
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+
+	// NOTE: ListenAndServe() returns http.ErrServerClosed straight away once Shutdown() is called
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", srv.Addr)
+
+	return nil
+}
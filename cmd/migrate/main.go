@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/harshk200/greenlight/internal/migrate"
+	"github.com/harshk200/greenlight/migrations"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+const usage = `usage: migrate [flags] <command>
+
+commands:
+  up          apply all the pending migrations
+  down [N]    roll back N migrations (1 by default)
+  version     print the current migration version
+  force V     set the version to V without running any migrations (clears the dirty flag)
+
+flags:
+`
+
+func main() {
+	// loading Environment variables
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading the .env variables", err)
+	}
+
+	var dns string
+
+	flag.StringVar(&dns, "db-dns", os.Getenv("POSTGRES_URL"), "DNS for the database (postgres-db)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("postgres", dns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// NOTE: cancelling on SIGINT/SIGTERM rolls back the migration that's running (each one runs in a transaction)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err = run(ctx, m, flag.Args())
+	if err != nil {
+		stop()
+		db.Close()
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, m *migrate.Migrator, args []string) error {
+	start := time.Now()
+
+	switch args[0] {
+	case "up":
+		err := m.Up(ctx)
+		if errors.Is(err, migrate.ErrNoChange) {
+			log.Print("no change")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+
+		err := m.Down(ctx, steps)
+		if errors.Is(err, migrate.ErrNoChange) {
+			log.Print("no change")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+	case "version":
+		// NOTE: handled below since every command prints the version
+
+	case "force":
+		if len(args) < 2 {
+			return errors.New("force requires a version")
+		}
+
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+
+		err = m.Force(ctx, version)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		log.Printf("version %d (dirty)", version)
+	} else {
+		log.Printf("version %d", version)
+	}
+
+	if args[0] != "version" {
+		log.Printf("finished %s in %s", args[0], time.Since(start))
+	}
+
+	return nil
+}
@@ -1,19 +1,147 @@
 package data
 
-import "github.com/harshk200/greenlight/internal/validator"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/harshk200/greenlight/internal/validator"
+)
 
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string // for e.g. id, year, -year NOTE: -year means decending order ascending by default
 	SortSafeList []string
+	CursorMode   bool   // NOTE: opt-in keyset pagination, Page is ignored when this is set
+	Cursor       string // opaque cursor returned as next_cursor by the previous call ("" for the first page)
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than 0")
 	v.Check(f.Page < 10_000_000, "page", "must be smaller than max 10000000")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than 0")
-	v.Check(f.PageSize > 0, "page_size", "maximum allowed value 100")
+	v.Check(f.PageSize <= 100, "page_size", "maximum allowed value 100")
 
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	if f.CursorMode && f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		v.Check(err == nil, "cursor", "invalid cursor")
+		// NOTE: the cursor holds the value of the sort column so it can't be reused with a different sort
+		v.Check(err != nil || c.Sort == f.Sort, "cursor", "cursor does not match the sort value")
+
+		// NOTE: the client can change the cursor so the value has to be checked against the column's type, otherwise
+		// postgres rejects it when it's compared to the integer columns
+		switch strings.TrimPrefix(c.Sort, "-") {
+		case "year", "runtime":
+			_, err := strconv.ParseInt(c.Value, 10, 32)
+			v.Check(err == nil, "cursor", "invalid cursor")
+		}
+	}
+}
+
+// returns the column name to sort by (with the "-" prefix stripped off)
+// NOTE: the column name gets interpolated directly into the sql query so it MUST be in the SortSafeList
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafeList {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+
+	// NOTE: this should never happen since ValidateFilters() already checks the sort value, hence panic as a failsafe
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// returns the sort direction ("ASC" or "DESC") depending on the "-" prefix of the sort value
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+
+	return "ASC"
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// pagination metadata sent along with the records so the client knows how many pages there are
+type Metadata struct {
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"` // only set in cursor mode when there are more records
+}
+
+// calculates the pagination Metadata from the total number of records, current page and page size
+// NOTE: returns an empty Metadata if there are no records (omitempty hides all the fields in that case)
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     (totalRecords + pageSize - 1) / pageSize, // NOTE: integer division rounded up
+		TotalRecords: totalRecords,
+	}
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the position of the last row of a page in keyset pagination
+// NOTE: it's sent to the client as base64 encoded json so the client treats it as an opaque string
+type cursor struct {
+	Sort  string `json:"s"`  // the sort value the cursor was created with e.g. -year
+	Value string `json:"v"`  // value of the sort column of the last row
+	ID    int64  `json:"id"` // id of the last row (secondary sort key)
+}
+
+func encodeCursor(c cursor) string {
+	jsonCursor, _ := json.Marshal(c) // NOTE: marshalling a struct of strings and ints can't fail
+
+	return base64.RawURLEncoding.EncodeToString(jsonCursor)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	jsonCursor, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+
+	err = json.Unmarshal(jsonCursor, &c)
+	if err != nil || c.ID < 1 {
+		return c, errInvalidCursor
+	}
+
+	return c, nil
+}
+
+// returns the value of the sort column for the provided movie as a string to be stored in a cursor
+// NOTE: postgres infers the type of the placeholder from the column it's compared to so a string is fine here
+func (f Filters) sortValue(movie *Movie) string {
+	switch f.sortColumn() {
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	default:
+		return strconv.FormatInt(movie.ID, 10)
+	}
 }
@@ -0,0 +1,676 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// constructor for Models backed by memory instead of postgres so the handlers can be tested without a db
+// NOTE: DB is nil so anything that uses it directly (e.g. the readiness check) still needs a real db
+func NewMockModels() Models {
+	tokens := NewMockTokenModel()
+
+	return Models{
+		Movies:      NewMockMovieModel(),
+		Users:       NewMockUserModel(tokens),
+		Tokens:      tokens,
+		Permissions: NewMockPermissionModel(),
+	}
+}
+
+// MockMovieModel is a thread safe in memory implementation of Movies that behaves like MovieModel
+// (version conflicts, title search, genre filters, sorting and pagination)
+type MockMovieModel struct {
+	mu        sync.RWMutex
+	movies    map[int64]*Movie
+	revisions map[int64][]*MovieRevision // by movie id, oldest first
+	nextID    int64
+}
+
+// constructor
+func NewMockMovieModel() *MockMovieModel {
+	return &MockMovieModel{
+		movies:    make(map[int64]*Movie),
+		revisions: make(map[int64][]*MovieRevision),
+		nextID:    1,
+	}
+}
+
+// NOTE: the movies are copied in and out of the map so callers can't change the stored ones behind our back
+func copyMovie(movie *Movie) *Movie {
+	c := *movie
+	c.Genres = append([]string(nil), movie.Genres...)
+
+	if movie.DeletedAt != nil {
+		deletedAt := *movie.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+
+	return &c
+}
+
+// records a revision like insertRevision() does, the caller must hold the write lock
+func (m *MockMovieModel) recordRevision(action string, movie *Movie, actorID int64) {
+	revision := &MovieRevision{
+		MovieID:   movie.ID,
+		Version:   movie.Version,
+		Action:    action,
+		Snapshot:  copyMovie(movie),
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+
+	if actorID > 0 {
+		revision.ActorID = &actorID
+	}
+
+	m.revisions[movie.ID] = append(m.revisions[movie.ID], revision)
+}
+
+func (m *MockMovieModel) Create(ctx context.Context, movie *Movie, actorID int64) error {
+	if err := ctx.Err(); err != nil {
+		return queryError(ctx, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie.ID = m.nextID
+	movie.CreatedAt = time.Now().Truncate(time.Second) // NOTE: timestamp(0) in postgres
+	movie.Version = 1
+
+	m.nextID++
+	m.movies[movie.ID] = copyMovie(movie)
+	m.recordRevision(RevisionCreate, movie, actorID)
+
+	return nil
+}
+
+func (m *MockMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movie, found := m.movies[id]
+	if !found || movie.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyMovie(movie), nil
+}
+
+func (m *MockMovieModel) GetAll(ctx context.Context, title string, genres []string, f Filters) ([]*Movie, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matches := []*Movie{}
+
+	for _, movie := range m.movies {
+		if movie.DeletedAt == nil && matchesTitle(movie.Title, title) && containsGenres(movie.Genres, genres) {
+			matches = append(matches, copyMovie(movie))
+		}
+	}
+
+	// NOTE: same order as the ORDER BY <column> <direction>, id ASC in MovieModel
+	sort.Slice(matches, func(i, j int) bool {
+		cmp := compareSortColumn(f, matches[i], matches[j])
+		if cmp == 0 {
+			return matches[i].ID < matches[j].ID
+		}
+
+		if f.sortDirection() == "DESC" {
+			return cmp > 0
+		}
+
+		return cmp < 0
+	})
+
+	if f.CursorMode {
+		return mockPageByCursor(matches, f)
+	}
+
+	start, end, metadata := mockPage(len(matches), f)
+
+	return matches[start:end], metadata, nil
+}
+
+func (m *MockMovieModel) Update(ctx context.Context, movie *Movie, actorID int64) error {
+	return m.update(ctx, movie, RevisionUpdate, actorID)
+}
+
+func (m *MockMovieModel) Revert(ctx context.Context, movie *Movie, actorID int64) error {
+	return m.update(ctx, movie, RevisionRevert, actorID)
+}
+
+func (m *MockMovieModel) update(ctx context.Context, movie *Movie, action string, actorID int64) error {
+	if err := ctx.Err(); err != nil {
+		return queryError(ctx, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// NOTE: same as the WHERE id = $5 AND version = $6 in MovieModel.Update()
+	stored, found := m.movies[movie.ID]
+	if !found || stored.DeletedAt != nil || stored.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	movie.Version++
+	m.movies[movie.ID] = copyMovie(movie)
+	m.recordRevision(action, movie, actorID)
+
+	return nil
+}
+
+func (m *MockMovieModel) Delete(ctx context.Context, id int64, expectedVersion int32, actorID int64) error {
+	if err := ctx.Err(); err != nil {
+		return queryError(ctx, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.movies[id]
+	if !found || stored.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+
+	if expectedVersion != 0 && stored.Version != expectedVersion {
+		return ErrEditConflict
+	}
+
+	// NOTE: soft delete, same as MovieModel.Delete()
+	now := time.Now().Truncate(time.Second)
+	stored.DeletedAt = &now
+	stored.Version++
+	m.recordRevision(RevisionDelete, stored, actorID)
+
+	return nil
+}
+
+func (m *MockMovieModel) GetAllDeleted(ctx context.Context, f Filters) ([]*Movie, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	deleted := []*Movie{}
+
+	for _, movie := range m.movies {
+		if movie.DeletedAt != nil {
+			deleted = append(deleted, copyMovie(movie))
+		}
+	}
+
+	// NOTE: same order as ORDER BY deleted_at DESC, id ASC
+	sort.Slice(deleted, func(i, j int) bool {
+		if deleted[i].DeletedAt.Equal(*deleted[j].DeletedAt) {
+			return deleted[i].ID < deleted[j].ID
+		}
+
+		return deleted[i].DeletedAt.After(*deleted[j].DeletedAt)
+	})
+
+	start, end, metadata := mockPage(len(deleted), f)
+
+	return deleted[start:end], metadata, nil
+}
+
+func (m *MockMovieModel) Restore(ctx context.Context, id int64, actorID int64) (*Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, found := m.movies[id]
+	if !found || stored.DeletedAt == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	stored.DeletedAt = nil
+	stored.Version++
+	m.recordRevision(RevisionRestore, stored, actorID)
+
+	return copyMovie(stored), nil
+}
+
+func (m *MockMovieModel) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+
+	for id, movie := range m.movies {
+		if movie.DeletedAt != nil && movie.DeletedAt.Before(deletedBefore) {
+			delete(m.movies, id) // NOTE: the revisions are kept, same as in the db
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (m *MockMovieModel) GetRevisions(ctx context.Context, movieID int64, f Filters) ([]*MovieRevision, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored := m.revisions[movieID]
+	if len(stored) == 0 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	// NOTE: newest first, same as ORDER BY version DESC
+	revisions := make([]*MovieRevision, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		revisions = append(revisions, copyRevision(stored[i]))
+	}
+
+	start, end, metadata := mockPage(len(revisions), f)
+
+	return revisions[start:end], metadata, nil
+}
+
+func (m *MockMovieModel) GetRevision(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, revision := range m.revisions[movieID] {
+		if revision.Version == version {
+			return copyRevision(revision), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func copyRevision(revision *MovieRevision) *MovieRevision {
+	c := *revision
+	c.Snapshot = copyMovie(revision.Snapshot)
+	return &c
+}
+
+// returns the bounds of the Filters' page in a slice of total records and the pagination Metadata for it
+// NOTE: a page past the last one gets an empty Metadata since count(*) OVER() has no rows to be read from in postgres
+func mockPage(total int, f Filters) (int, int, Metadata) {
+	start := min(f.offset(), total)
+	end := min(start+f.limit(), total)
+
+	if start == end {
+		return start, end, Metadata{}
+	}
+
+	return start, end, calculateMetadata(total, f.Page, f.PageSize)
+}
+
+// keyset pagination over the already sorted movies, the in memory version of MovieModel.getAllByCursor()
+func mockPageByCursor(sorted []*Movie, f Filters) ([]*Movie, Metadata, error) {
+	start := 0
+
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// NOTE: the page starts at the first movie that comes after the cursor's position
+		start = len(sorted)
+		for i, movie := range sorted {
+			if afterCursor(f, movie, c) {
+				start = i
+				break
+			}
+		}
+	}
+
+	end := min(start+f.limit(), len(sorted))
+	movies := sorted[start:end]
+
+	metadata := Metadata{PageSize: f.PageSize}
+
+	if end < len(sorted) {
+		last := movies[len(movies)-1]
+		metadata.NextCursor = encodeCursor(cursor{Sort: f.Sort, Value: f.sortValue(last), ID: last.ID})
+	}
+
+	return movies, metadata, nil
+}
+
+// returns true if the movie comes after the cursor in the Filters' sort order
+func afterCursor(f Filters, movie *Movie, c cursor) bool {
+	var cmp int
+
+	if f.sortColumn() == "id" {
+		cmp = compareInt(movie.ID, c.ID)
+	} else {
+		// NOTE: same as the keyset condition in MovieModel.getAllByCursor() where postgres compares the cursor's value
+		// as text for the title and as an integer for year and runtime
+		if f.sortColumn() == "title" {
+			cmp = strings.Compare(movie.Title, c.Value)
+		} else {
+			value, _ := strconv.ParseInt(c.Value, 10, 64) // NOTE: ValidateFilters() has already checked it parses
+			current, _ := strconv.ParseInt(f.sortValue(movie), 10, 64)
+			cmp = compareInt(current, value)
+		}
+
+		// NOTE: the id tie breaker is always ascending
+		if cmp == 0 {
+			return movie.ID > c.ID
+		}
+	}
+
+	if f.sortDirection() == "DESC" {
+		return cmp < 0
+	}
+
+	return cmp > 0
+}
+
+// compares the sort column of 2 movies, returns -1, 0 or +1
+func compareSortColumn(f Filters, a, b *Movie) int {
+	switch f.sortColumn() {
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "year":
+		return compareInt(int64(a.Year), int64(b.Year))
+	case "runtime":
+		return compareInt(int64(a.Runtime), int64(b.Runtime))
+	default:
+		return compareInt(a.ID, b.ID)
+	}
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// approximates to_tsvector('simple', title) @@ plainto_tsquery('simple', search)
+// i.e. every word of the search has to be a word of the title (case insensitive)
+func matchesTitle(title, search string) bool {
+	searchWords := tsWords(search)
+	if len(searchWords) == 0 {
+		return true
+	}
+
+	titleWords := make(map[string]bool)
+	for _, word := range tsWords(title) {
+		titleWords[word] = true
+	}
+
+	for _, word := range searchWords {
+		if !titleWords[word] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func tsWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// same as genres @> $2 i.e. the movie has all of the wanted genres
+func containsGenres(movieGenres, wanted []string) bool {
+	for _, genre := range wanted {
+		found := false
+
+		for _, movieGenre := range movieGenres {
+			if movieGenre == genre {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MockUserModel is a thread safe in memory implementation of Users that behaves like UserModel
+// NOTE: it looks up the tokens in the MockTokenModel for GetForToken() like the join on the tokens table does
+type MockUserModel struct {
+	mu     sync.RWMutex
+	users  map[int64]*User
+	tokens *MockTokenModel
+	nextID int64
+}
+
+// constructor
+func NewMockUserModel(tokens *MockTokenModel) *MockUserModel {
+	return &MockUserModel{
+		users:  make(map[int64]*User),
+		tokens: tokens,
+		nextID: 1,
+	}
+}
+
+func copyUser(user *User) *User {
+	c := *user
+	c.Password.plaintext = nil // NOTE: the plaintext is never stored, same as in the db
+	c.Password.hash = append([]byte(nil), user.Password.hash...)
+	return &c
+}
+
+// returns true if another user already has the email, the caller must hold the lock
+// NOTE: case insensitive since the email column is citext
+func (m *MockUserModel) emailTaken(email string, id int64) bool {
+	for _, user := range m.users {
+		if user.ID != id && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *MockUserModel) Insert(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	user.ID = m.nextID
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+
+	m.nextID++
+	m.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+func (m *MockUserModel) GetByEmail(email string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m *MockUserModel) Update(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	// NOTE: same as the WHERE id = $5 AND version = $6 in UserModel.Update()
+	stored, found := m.users[user.ID]
+	if !found || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	user.Version++
+	m.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+func (m *MockUserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	userID, found := m.tokens.userFor(tokenHash[:], tokenScope)
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, found := m.users[userID]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// MockTokenModel is a thread safe in memory implementation of Tokens that behaves like TokenModel
+type MockTokenModel struct {
+	mu     sync.RWMutex
+	tokens []*Token
+}
+
+// constructor
+func NewMockTokenModel() *MockTokenModel {
+	return &MockTokenModel{}
+}
+
+func (m *MockTokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+func (m *MockTokenModel) Insert(token *Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// NOTE: only the hash is stored, same as in the db
+	stored := *token
+	stored.Plaintext = ""
+	m.tokens = append(m.tokens, &stored)
+
+	return nil
+}
+
+func (m *MockTokenModel) DeleteAllForUser(scope string, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.tokens[:0]
+	for _, token := range m.tokens {
+		if token.Scope != scope || token.UserID != userID {
+			kept = append(kept, token)
+		}
+	}
+
+	m.tokens = kept
+
+	return nil
+}
+
+// returns the id of the user a token with the given hash and scope belongs to if it hasn't expired
+func (m *MockTokenModel) userFor(tokenHash []byte, scope string) (int64, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, token := range m.tokens {
+		if bytes.Equal(token.Hash, tokenHash) && token.Scope == scope && token.Expiry.After(time.Now()) {
+			return token.UserID, true
+		}
+	}
+
+	return 0, false
+}
+
+// MockPermissionModel is a thread safe in memory implementation of PermissionStore that behaves like PermissionModel
+type MockPermissionModel struct {
+	mu          sync.RWMutex
+	permissions map[int64]Permissions // by user id
+}
+
+// constructor
+func NewMockPermissionModel() *MockPermissionModel {
+	return &MockPermissionModel{
+		permissions: make(map[int64]Permissions),
+	}
+}
+
+// NOTE: the codes inserted into the permissions table by the migrations
+var mockPermissionCodes = Permissions{"movies:read", "movies:write"}
+
+func (m *MockPermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append(Permissions(nil), m.permissions[userID]...), nil
+}
+
+func (m *MockPermissionModel) AddForUser(userID int64, codes ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// NOTE: unknown codes are ignored and existing ones aren't added twice, same as ON CONFLICT DO NOTHING
+	for _, code := range codes {
+		if mockPermissionCodes.Include(code) && !m.permissions[userID].Include(code) {
+			m.permissions[userID] = append(m.permissions[userID], code)
+		}
+	}
+
+	return nil
+}
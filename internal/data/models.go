@@ -1,24 +1,106 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
     ErrEditConflict = errors.New("edit conflict")
+	ErrQueryCanceled  = errors.New("query canceled") // NOTE: the caller's context was cancelled e.g. the client went away
 )
 
+// Movies is implemented by MovieModel (postgres) and MockMovieModel (in memory, for tests)
+type Movies interface {
+	Create(ctx context.Context, movie *Movie, actorID int64) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	GetAll(ctx context.Context, title string, genres []string, f Filters) ([]*Movie, Metadata, error)
+	Update(ctx context.Context, movie *Movie, actorID int64) error
+	Revert(ctx context.Context, movie *Movie, actorID int64) error
+	Delete(ctx context.Context, id int64, expectedVersion int32, actorID int64) error // NOTE: expectedVersion 0 skips the version check
+	GetAllDeleted(ctx context.Context, f Filters) ([]*Movie, Metadata, error)
+	Restore(ctx context.Context, id int64, actorID int64) (*Movie, error)
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error)
+	GetRevisions(ctx context.Context, movieID int64, f Filters) ([]*MovieRevision, Metadata, error)
+	GetRevision(ctx context.Context, movieID int64, version int32) (*MovieRevision, error)
+}
+
+// Users is implemented by UserModel (postgres) and MockUserModel (in memory, for tests)
+type Users interface {
+	Insert(user *User) error
+	GetByEmail(email string) (*User, error)
+	Update(user *User) error
+	GetForToken(tokenScope, tokenPlaintext string) (*User, error)
+}
+
+// Tokens is implemented by TokenModel (postgres) and MockTokenModel (in memory, for tests)
+type Tokens interface {
+	New(userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(token *Token) error
+	DeleteAllForUser(scope string, userID int64) error
+}
+
+// PermissionStore is implemented by PermissionModel (postgres) and MockPermissionModel (in memory, for tests)
+// NOTE: not called Permissions like the others since that's the name of the permission codes type
+type PermissionStore interface {
+	GetAllForUser(userID int64) (Permissions, error)
+	AddForUser(userID int64, codes ...string) error
+}
+
 type Models struct {
-	DB     *sql.DB
-	Movies MovieModel
+	DB          *sql.DB
+	Movies      Movies
+	Users       Users
+	Tokens      Tokens
+	Permissions PermissionStore
 }
 
 // constructor
-func NewModels(db *sql.DB) Models {
+// NOTE: queryTimeout is the max time a single movie query can take (on top of the request's context)
+func NewModels(db *sql.DB, queryTimeout time.Duration) Models {
 	return Models{
-		DB:     db,
-		Movies: MovieModel{DB: db},
+		DB:          db,
+		Movies:      &MovieModel{DB: db, QueryTimeout: queryTimeout},
+		Users:       &UserModel{DB: db},
+		Tokens:      &TokenModel{DB: db},
+		Permissions: &PermissionModel{DB: db},
+	}
+}
+
+// returns the current version of the db schema from the schema_migrations table and whether the last migration failed
+// half way through (dirty)
+// NOTE: the caller's ctx bounds the query so a readiness probe gives up when its client does
+func (m Models) SchemaVersion(ctx context.Context) (int64, bool, error) {
+	query := `
+    SELECT version, dirty
+    FROM schema_migrations
+    LIMIT 1`
+
+	var version int64
+	var dirty bool
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, ErrRecordNotFound
+		default:
+			return 0, false, err
+		}
 	}
+
+	return version, dirty, nil
+}
+
+// returns ErrQueryCanceled instead of the query error if the context the query ran with was cancelled
+// NOTE: a query hitting its own timeout is still a server error so context.DeadlineExceeded is returned as is
+func queryError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return ErrQueryCanceled
+	}
+
+	return err
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/harshk200/greenlight/internal/validator"
@@ -11,13 +12,14 @@ import (
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-    CreatedAt time.Time `json:"-"` // NOTE: this isn't relative for end-user hence use - directive
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty"` // movie runtime (in minutes)
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID        int64      `json:"id"`
+    CreatedAt time.Time  `json:"-"` // NOTE: this isn't relative for end-user hence use - directive
+	Title     string     `json:"title"`
+	Year      int32      `json:"year,omitempty"`
+	Runtime   Runtime    `json:"runtime,omitempty"` // movie runtime (in minutes)
+	Genres    []string   `json:"genres,omitempty"`
+	Version   int32      `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // NOTE: only set for movies in the trash
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -37,11 +39,13 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate generes")
 }
 
+// NOTE: every write records a revision (see revisions.go) in the same transaction so the history can't miss a change
 type MovieModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration // NOTE: applied on top of the context passed in by the caller
 }
 
-func (m *MovieModel) Create(movie *Movie) error {
+func (m *MovieModel) Create(ctx context.Context, movie *Movie, actorID int64) error {
 	query := `
     INSERT INTO movies (title, year, runtime, genres)
     VALUES ($1, $2, $3, $4)
@@ -54,32 +58,134 @@ func (m *MovieModel) Create(movie *Movie) error {
 		pq.Array(movie.Genres),
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := m.withTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, RevisionCreate, movie, actorID)
+	})
+
+	return queryError(ctx, err)
 }
 
-func (m *MovieModel) GetAll(title string, genres []string, f Filters) ([]*Movie, error) {
-    // HACK: the current filter being applied is hardcoded to ID in decending order i.e. -id
-	query := `
-    Select id, created_at, title, year, runtime, genres, version
+func (m *MovieModel) GetAll(ctx context.Context, title string, genres []string, f Filters) ([]*Movie, Metadata, error) {
+	if f.CursorMode {
+		return m.getAllByCursor(ctx, title, genres, f)
+	}
+
+	// NOTE: the sort column and direction can't be passed as placeholder args hence the Sprintf
+	// (they're safe since sortColumn() only returns values from the SortSafeList)
+	// id is used as a secondary sort key so that rows with the same sort column value have a stable order
+	// NOTE: count(*) OVER() is a window function which gives the total no. of filtered rows (before LIMIT/OFFSET)
+	// NOTE: title is matched using postgres full-text search, plainto_tsquery() splits the search term into words
+	// so "panther" matches "Black Panther" ('simple' config is used so words aren't stemmed or dropped as stop words)
+	query := fmt.Sprintf(`
+    Select count(*) OVER(), id, created_at, title, year, runtime, genres, version
     FROM movies
-    WHERE (LOWER(title) = LOWER($1) OR $1 = '')
+    WHERE deleted_at IS NULL
+    AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
     AND (genres @> $2 OR $2 = '{}')
-    ORDER BY id` // Default ordering ascending
+    ORDER BY %s %s, id ASC
+    LIMIT $3 OFFSET $4`, f.sortColumn(), f.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres)) // looking for multiple rows hence using QueryContext here
+	args := []any{title, pq.Array(genres), f.limit(), f.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...) // looking for multiple rows hence using QueryContext here
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, queryError(ctx, err)
 	}
 
 	// defering a call to rows.close to ensure that resultset is closed before GetAll() returns
 	defer rows.Close()
 
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	// NOTE: rows.Next() returns false on errors as well so checking if the iteration ended because of an error
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := calculateMetadata(totalRecords, f.Page, f.PageSize)
+
+	return movies, metadata, nil
+}
+
+// keyset pagination version of GetAll(), instead of an OFFSET the rows after the cursor's (sort value, id) are fetched
+// NOTE: this stays fast and stable on large tables that are being written to, but there's no total_records/last_page
+func (m *MovieModel) getAllByCursor(ctx context.Context, title string, genres []string, f Filters) ([]*Movie, Metadata, error) {
+	args := []any{title, pq.Array(genres), f.limit() + 1} // NOTE: fetching 1 extra row to know if there's a next page
+
+	keysetCondition := ""
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// NOTE: the sort column goes in a direction of its own but id (the tie breaker) is always ascending
+		operator := ">"
+		if f.sortDirection() == "DESC" {
+			operator = "<"
+		}
+
+		if f.sortColumn() == "id" {
+			keysetCondition = fmt.Sprintf("AND id %s $4", operator)
+			args = append(args, c.ID)
+		} else {
+			keysetCondition = fmt.Sprintf("AND (%[1]s %[2]s $4 OR (%[1]s = $4 AND id > $5))", f.sortColumn(), operator)
+			args = append(args, c.Value, c.ID)
+		}
+	}
+
+	query := fmt.Sprintf(`
+    Select id, created_at, title, year, runtime, genres, version
+    FROM movies
+    WHERE deleted_at IS NULL
+    AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+    AND (genres @> $2 OR $2 = '{}')
+    %s
+    ORDER BY %s %s, id ASC
+    LIMIT $3`, keysetCondition, f.sortColumn(), f.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
 	movies := []*Movie{}
 
 	for rows.Next() {
@@ -96,16 +202,30 @@ func (m *MovieModel) GetAll(title string, genres []string, f Filters) ([]*Movie,
 		)
 
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, queryError(ctx, err)
 		}
 
 		movies = append(movies, &movie)
 	}
 
-	return movies, nil
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := Metadata{PageSize: f.PageSize}
+
+	// NOTE: if the extra row came back there's a next page, which starts after the last row we actually return
+	if len(movies) > f.limit() {
+		movies = movies[:f.limit()]
+		last := movies[len(movies)-1]
+
+		metadata.NextCursor = encodeCursor(cursor{Sort: f.Sort, Value: f.sortValue(last), ID: last.ID})
+	}
+
+	return movies, metadata, nil
 }
 
-func (m *MovieModel) Get(id int64) (*Movie, error) {
+func (m *MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -113,11 +233,11 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 	query := `
     SELECT id, created_at, title, year, runtime, genres, version
     FROM movies
-    WHERE id = $1`
+    WHERE id = $1 AND deleted_at IS NULL`
 
 	var movie Movie
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
@@ -135,18 +255,29 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 
 	return &movie, nil
 }
 
-func (m *MovieModel) Update(movie *Movie) error {
+func (m *MovieModel) Update(ctx context.Context, movie *Movie, actorID int64) error {
+	return m.update(ctx, movie, RevisionUpdate, actorID)
+}
+
+// same as Update() (including the version check) but the revision is recorded as a revert
+// NOTE: the caller copies the fields of the old revision's snapshot into the movie before calling this
+func (m *MovieModel) Revert(ctx context.Context, movie *Movie, actorID int64) error {
+	return m.update(ctx, movie, RevisionRevert, actorID)
+}
+
+// updates the movie and records the revision with the given action
+func (m *MovieModel) update(ctx context.Context, movie *Movie, action string, actorID int64) error {
 	query := `
     UPDATE movies
     SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-    WHERE id = $5 AND version = $6
+    WHERE id = $5 AND version = $6 AND deleted_at IS NULL
     RETURNING version`
 
 	args := []any{
@@ -158,44 +289,215 @@ func (m *MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	// NOTE: this returns the updated movies and writes it to the movie parameter
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := m.withTx(ctx, func(tx *sql.Tx) error {
+		// NOTE: this returns the updated movies and writes it to the movie parameter
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, action, movie, actorID)
+	})
+
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// moves the movie to the trash (soft delete), if expectedVersion isn't 0 the movie is only deleted if it's still at
+// that version (same optimistic locking as Update()) otherwise ErrEditConflict is returned
+// NOTE: the version is bumped so ETags fetched before the delete don't match the movie once it's restored
+func (m *MovieModel) Delete(ctx context.Context, id int64, expectedVersion int32, actorID int64) error {
+	query := `
+    UPDATE movies
+    SET deleted_at = NOW(), version = version + 1
+    WHERE id = $1 AND ($2 = 0 OR version = $2) AND deleted_at IS NULL
+    RETURNING id, created_at, title, year, runtime, genres, version, deleted_at`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.withTx(ctx, func(tx *sql.Tx) error {
+		var movie Movie
+
+		err := tx.QueryRowContext(ctx, query, id, expectedVersion).Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+		if err != nil {
 			return err
 		}
+
+		return insertRevision(ctx, tx, RevisionDelete, &movie, actorID)
+	})
+
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return queryError(ctx, err)
+		}
+
+		if expectedVersion == 0 {
+			return ErrRecordNotFound
+		}
+
+		// NOTE: nothing was deleted, either the movie doesn't exist or its version has changed
+		var exists bool
+
+		err = m.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		if exists {
+			return ErrEditConflict
+		}
+
+		return ErrRecordNotFound
 	}
 
 	return nil
 }
 
-func (m *MovieModel) Delete(id int64) error {
+// returns the movies in the trash, most recently deleted first
+func (m *MovieModel) GetAllDeleted(ctx context.Context, f Filters) ([]*Movie, Metadata, error) {
+	query := `
+    SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, deleted_at
+    FROM movies
+    WHERE deleted_at IS NOT NULL
+    ORDER BY deleted_at DESC, id ASC
+    LIMIT $1 OFFSET $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.limit(), f.offset())
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := calculateMetadata(totalRecords, f.Page, f.PageSize)
+
+	return movies, metadata, nil
+}
+
+// takes the movie out of the trash, returns ErrRecordNotFound if the movie isn't in the trash
+func (m *MovieModel) Restore(ctx context.Context, id int64, actorID int64) (*Movie, error) {
+	query := `
+    UPDATE movies
+    SET deleted_at = NULL, version = version + 1
+    WHERE id = $1 AND deleted_at IS NOT NULL
+    RETURNING id, created_at, title, year, runtime, genres, version`
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	err := m.withTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, id).Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		return insertRevision(ctx, tx, RevisionRestore, &movie, actorID)
+	})
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &movie, nil
+}
+
+// permanently deletes the movies that were moved to the trash before the given time, returns how many were deleted
+// NOTE: the revisions of the purged movies are kept so their history can still be read
+func (m *MovieModel) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
 	query := `
     DELETE FROM movies
-    WHERE id = $1`
+    WHERE deleted_at IS NOT NULL AND deleted_at < $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, deletedBefore)
 	if err != nil {
-		return err
+		return 0, queryError(ctx, err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	return result.RowsAffected()
+}
+
+// runs fn in a transaction, committing it if fn returns nil and rolling it back otherwise
+func (m *MovieModel) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback() // NOTE: no-op once the tx has been committed
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	err = fn(tx)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
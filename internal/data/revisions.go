@@ -0,0 +1,158 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// the kind of write a revision was recorded for
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+)
+
+// MovieRevision is a full snapshot of a movie right after one of its writes
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"` // NOTE: the version of the movie after the write
+	Action    string    `json:"action"`
+	Snapshot  *Movie    `json:"snapshot"`
+	ActorID   *int64    `json:"actor_id"` // NOTE: nil if the user has been deleted or the write wasn't made by a user
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// records a revision of the movie in the same transaction as the write
+// NOTE: an actorID of 0 (e.g. the anonymous user) is stored as NULL
+func insertRevision(ctx context.Context, tx *sql.Tx, action string, movie *Movie, actorID int64) error {
+	query := `
+    INSERT INTO movie_revisions (movie_id, version, action, snapshot, actor_id)
+    VALUES ($1, $2, $3, $4, $5)`
+
+	snapshot, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	var actor sql.NullInt64
+	if actorID > 0 {
+		actor = sql.NullInt64{Int64: actorID, Valid: true}
+	}
+
+	args := []any{movie.ID, movie.Version, action, snapshot, actor}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// returns the revisions of the movie, newest first
+// NOTE: returns ErrRecordNotFound if the movie has no revisions at all (every movie has at least one, from its creation
+// or from the backfill in the migration)
+func (m *MovieModel) GetRevisions(ctx context.Context, movieID int64, f Filters) ([]*MovieRevision, Metadata, error) {
+	query := `
+    SELECT count(*) OVER(), movie_id, version, action, snapshot, actor_id, created_at
+    FROM movie_revisions
+    WHERE movie_id = $1
+    ORDER BY version DESC
+    LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, f.limit(), f.offset())
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		revision, err := scanRevision(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		revisions = append(revisions, revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	// NOTE: an empty page can also be a page past the last one, count(*) OVER() has no rows to tell them apart
+	if len(revisions) == 0 {
+		var exists bool
+
+		err = m.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM movie_revisions WHERE movie_id = $1)`, movieID).Scan(&exists)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		if !exists {
+			return nil, Metadata{}, ErrRecordNotFound
+		}
+	}
+
+	metadata := calculateMetadata(totalRecords, f.Page, f.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// returns the revision of the movie at the given version
+func (m *MovieModel) GetRevision(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	query := `
+    SELECT movie_id, version, action, snapshot, actor_id, created_at
+    FROM movie_revisions
+    WHERE movie_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	revision, err := scanRevision(m.DB.QueryRowContext(ctx, query, movieID, version), nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return revision, nil
+}
+
+// scans a movie_revisions row, if totalRecords isn't nil the row is expected to start with the count(*) OVER() column
+// NOTE: works for both *sql.Row and *sql.Rows
+func scanRevision(row interface{ Scan(dest ...any) error }, totalRecords *int) (*MovieRevision, error) {
+	var revision MovieRevision
+	var snapshot []byte
+	var actor sql.NullInt64
+
+	dest := []any{&revision.MovieID, &revision.Version, &revision.Action, &snapshot, &actor, &revision.CreatedAt}
+	if totalRecords != nil {
+		dest = append([]any{totalRecords}, dest...)
+	}
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(snapshot, &revision.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	if actor.Valid {
+		revision.ActorID = &actor.Int64
+	}
+
+	return &revision, nil
+}
@@ -0,0 +1,25 @@
+package mailer
+
+import "log/slog"
+
+// Log writes the emails to the logger instead of sending them, for development when there's no smtp server
+// NOTE: nothing is kept around so unlike Memory it doesn't grow over time
+type Log struct {
+	logger *slog.Logger
+}
+
+// constructor
+func NewLog(logger *slog.Logger) *Log {
+	return &Log{logger: logger}
+}
+
+func (l *Log) Send(recipient, templateFile string, data any) error {
+	message, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	l.logger.Info("email not sent (no smtp host)", "recipient", message.Recipient, "subject", message.Subject, "body", message.PlainBody)
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmlTemplate "html/template"
+	textTemplate "text/template"
+)
+
+// NOTE: the email templates are embedded into the binary so we don't depend on the working directory at runtime
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer sends the email template (from the templates dir) rendered with the given data to the recipient
+// NOTE: an interface so the SMTP mailer can be swapped out for the in-memory one in dev/tests
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// a rendered email
+type Message struct {
+	Recipient string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// renders the "subject", "plainBody" and "htmlBody" templates defined in the template file
+func render(recipient, templateFile string, data any) (*Message, error) {
+	textTmpl, err := textTemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: html/template escapes the data so it can't inject html into the email
+	htmlTmpl, err := htmlTemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &Message{
+		Recipient: recipient,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}
+
+	return message, nil
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import "sync"
+
+// Memory keeps the sent emails in memory instead of sending them, for tests (no network needed)
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// constructor
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Send(recipient, templateFile string, data any) error {
+	message, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, *message)
+
+	return nil
+}
+
+// returns a copy of all the emails sent so far
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]Message, len(m.messages))
+	copy(messages, m.messages)
+
+	return messages
+}
@@ -0,0 +1,199 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+const (
+	// max time a single attempt at sending an email (dial + the whole smtp conversation) can take
+	// NOTE: smtp.SendMail() has no timeouts at all so a hung smtp server would block the sending goroutine forever
+	sendTimeout = 10 * time.Second
+	// max time Send() can take over all of its attempts
+	// NOTE: kept under the default -shutdown-timeout (30s) so an email sent during a shutdown doesn't outlive it
+	maxSendTime  = 20 * time.Second
+	sendAttempts = 3
+	retryDelay   = 500 * time.Millisecond
+)
+
+// SMTP sends emails through an smtp server
+type SMTP struct {
+	host   string
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+// constructor
+// NOTE: sender is the From header e.g. "Greenlight <no-reply@greenlight.harshk200.net>"
+func NewSMTP(host string, port int, username, password, sender string) *SMTP {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTP{
+		host:   host,
+		addr:   host + ":" + strconv.Itoa(port),
+		auth:   auth,
+		sender: sender,
+	}
+}
+
+func (s *SMTP) Send(recipient, templateFile string, data any) error {
+	message, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(s.sender)
+	if err != nil {
+		return err
+	}
+
+	body, err := s.buildMIME(message)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(maxSendTime)
+
+	// NOTE: retrying a few times in case of temporary network/smtp server errors, as long as there's time left
+	for i := 1; i <= sendAttempts; i++ {
+		err = s.send(from.Address, recipient, body, deadline)
+		if err == nil {
+			return nil
+		}
+
+		if i == sendAttempts || time.Until(deadline) <= retryDelay {
+			break
+		}
+
+		time.Sleep(retryDelay)
+	}
+
+	return err
+}
+
+// does the same as smtp.SendMail() but with a deadline on the connection, the earlier of sendTimeout from now and
+// the deadline of the whole Send()
+func (s *SMTP) send(from, recipient string, body []byte, deadline time.Time) error {
+	if attemptDeadline := time.Now().Add(sendTimeout); attemptDeadline.Before(deadline) {
+		deadline = attemptDeadline
+	}
+
+	conn, err := net.DialTimeout("tcp", s.addr, time.Until(deadline))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// NOTE: same as smtp.SendMail(), upgrade to tls when the server supports it
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: s.host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.auth != nil {
+		err = client.Auth(s.auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(from)
+	if err != nil {
+		return err
+	}
+
+	err = client.Rcpt(recipient)
+	if err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(body)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	// NOTE: the server has accepted the message once DATA is closed, a failed QUIT is ignored since returning it would
+	// make Send() retry and the user get the email twice
+	_ = client.Quit()
+
+	return nil
+}
+
+// builds a multipart/alternative email with both the plain text and html bodies
+func (s *SMTP) buildMIME(message *Message) ([]byte, error) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", message.PlainBody},
+		{"text/html; charset=UTF-8", message.HTMLBody}, // NOTE: the last part is the preferred one
+	}
+
+	for _, p := range parts {
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType}})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = part.Write([]byte(p.content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", s.sender)
+	fmt.Fprintf(msg, "To: %s\r\n", message.Recipient)
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", message.Subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: multipart/alternative; boundary=%s\r\n", writer.Boundary())
+	fmt.Fprintf(msg, "\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
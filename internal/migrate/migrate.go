@@ -0,0 +1,310 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrDirty          = errors.New("database is dirty, fix the failed migration by hand and then force the version")
+	ErrNoChange       = errors.New("no change")
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+// NOTE: arbitrary key for pg_advisory_lock(), every instance of the app must use the same one
+const advisoryLockKey = 7269746
+
+type migration struct {
+	version int64
+	name    string
+	up      string // file names in the fs
+	down    string
+}
+
+// Migrator applies the sql migrations from a fs.FS and tracks the current version in the schema_migrations table
+// NOTE: schema_migrations has the same layout as golang-migrate's so both can be used on the same db
+type Migrator struct {
+	db         *sql.DB
+	fsys       fs.FS
+	migrations []migration // sorted by version in ascending order
+}
+
+// constructor, reads the migration file names from the root of fsys
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		if entry.IsDir() || !strings.HasSuffix(fileName, ".sql") {
+			continue
+		}
+
+		// e.g. 000001_create_movies_table.up.sql -> 000001, create_movies_table.up.sql
+		versionStr, rest, found := strings.Cut(fileName, "_")
+		if !found {
+			return nil, fmt.Errorf("invalid migration file name %q", fileName)
+		}
+
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid migration version in file name %q", fileName)
+		}
+
+		mig, exists := byVersion[version]
+		if !exists {
+			mig = &migration{version: version}
+			byVersion[version] = mig
+		}
+
+		switch {
+		case strings.HasSuffix(rest, ".up.sql"):
+			mig.name = strings.TrimSuffix(rest, ".up.sql")
+			mig.up = fileName
+		case strings.HasSuffix(rest, ".down.sql"):
+			mig.name = strings.TrimSuffix(rest, ".down.sql")
+			mig.down = fileName
+		default:
+			return nil, fmt.Errorf("migration file name %q must end with .up.sql or .down.sql", fileName)
+		}
+	}
+
+	m := &Migrator{db: db, fsys: fsys}
+
+	for _, mig := range byVersion {
+		if mig.up == "" || mig.down == "" {
+			return nil, fmt.Errorf("migration %d must have both an up and a down file", mig.version)
+		}
+
+		m.migrations = append(m.migrations, *mig)
+	}
+
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].version < m.migrations[j].version
+	})
+
+	return m, nil
+}
+
+// applies all the migrations newer than the current version, returns ErrNoChange if there were none
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if dirty {
+			return ErrDirty
+		}
+
+		applied := 0
+
+		for _, mig := range m.migrations {
+			if mig.version <= current {
+				continue
+			}
+
+			err := m.apply(ctx, conn, mig.up, mig.version)
+			if err != nil {
+				return fmt.Errorf("migration %d (%s) up: %w", mig.version, mig.name, err)
+			}
+
+			applied++
+		}
+
+		if applied == 0 {
+			return ErrNoChange
+		}
+
+		return nil
+	})
+}
+
+// rolls back the given no. of migrations starting from the current version, returns ErrNoChange if there were none
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := m.version(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if dirty {
+			return ErrDirty
+		}
+
+		applied := 0
+
+		for i := len(m.migrations) - 1; i >= 0 && applied < steps; i-- {
+			mig := m.migrations[i]
+
+			if mig.version > current {
+				continue
+			}
+
+			// NOTE: the version after rolling back is the one of the previous migration (0 if there are none left)
+			var previous int64
+			if i > 0 {
+				previous = m.migrations[i-1].version
+			}
+
+			err := m.apply(ctx, conn, mig.down, previous)
+			if err != nil {
+				return fmt.Errorf("migration %d (%s) down: %w", mig.version, mig.name, err)
+			}
+
+			applied++
+		}
+
+		if applied == 0 {
+			return ErrNoChange
+		}
+
+		return nil
+	})
+}
+
+// returns the current version (0 if no migrations have been applied) and whether it's dirty
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		version, dirty, err = m.version(ctx, conn)
+		return err
+	})
+
+	return version, dirty, err
+}
+
+// sets the version without running any migrations and clears the dirty flag
+// NOTE: only meant for fixing the db by hand after a failed migration
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	if version != 0 && !m.known(version) {
+		return ErrUnknownVersion
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() // NOTE: no-op once the tx has been committed
+
+		err = setVersion(ctx, tx, version)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+}
+
+func (m *Migrator) known(version int64) bool {
+	for _, mig := range m.migrations {
+		if mig.version == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runs the sql file and sets the new version in a single transaction so a failed migration leaves nothing behind
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, fileName string, newVersion int64) error {
+	query, err := fs.ReadFile(m.fsys, fileName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, string(query))
+	if err != nil {
+		return err
+	}
+
+	err = setVersion(ctx, tx, newVersion)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// NOTE: schema_migrations only ever holds a single row (none for version 0)
+func setVersion(ctx context.Context, tx *sql.Tx, version int64) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version)
+	return err
+}
+
+func (m *Migrator) version(ctx context.Context, conn *sql.Conn) (int64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
+
+// runs fn while holding a postgres advisory lock so concurrent deploys don't run the migrations at the same time
+// NOTE: advisory locks belong to a session hence everything runs on the same connection
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockKey)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: using a fresh context for the unlock so it still runs if ctx has been cancelled
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, advisoryLockKey)
+
+	_, err = conn.ExecContext(ctx, `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version bigint NOT NULL PRIMARY KEY,
+        dirty boolean NOT NULL
+    )`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_title_idx;
+
+DROP INDEX IF EXISTS movies_genres_idx;
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activated bool NOT NULL DEFAULT false;
@@ -0,0 +1,35 @@
+-- NOTE: movie_id isn't a foreign key so the revisions outlive the movie when it's purged from the trash
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL,
+    version integer NOT NULL,
+    action text NOT NULL,
+    snapshot jsonb NOT NULL,
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (movie_id, version)
+);
+
+-- NOTE: the existing movies get their current state as the first revision, the snapshot has to match the json of
+-- data.Movie (e.g. runtime as "<n> mins") since it's unmarshalled into one
+INSERT INTO movie_revisions (movie_id, version, action, snapshot, created_at)
+SELECT
+    id,
+    version,
+    CASE
+        WHEN deleted_at IS NOT NULL THEN 'delete'
+        WHEN version = 1 THEN 'create'
+        ELSE 'update'
+    END,
+    jsonb_strip_nulls(jsonb_build_object(
+        'id', id,
+        'title', title,
+        'year', year,
+        'runtime', runtime || ' mins',
+        'genres', genres,
+        'version', version,
+        'deleted_at', deleted_at
+    )),
+    CASE WHEN version = 1 THEN created_at ELSE NOW() END
+FROM movies
+ON CONFLICT (movie_id, version) DO NOTHING;
@@ -0,0 +1,9 @@
+package migrations
+
+import "embed"
+
+// the sql migration files embedded so the binary can apply them without the files being on disk
+// NOTE: files are named <version>_<name>.<up|down>.sql e.g. 000001_create_movies_table.up.sql
+//
+//go:embed *.sql
+var FS embed.FS